@@ -0,0 +1,53 @@
+// Package filunits parses and formats Filecoin monetary values. Callers deal
+// in either FIL or attoFIL (10^18 attoFIL per FIL); everything is backed by
+// *big.Int so values beyond int64 range don't silently wrap.
+package filunits
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// attoPerFIL is 10^18, the number of attoFIL in one FIL.
+var attoPerFIL = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+// AttoFIL parses s as a base-10 integer number of attoFIL.
+func AttoFIL(s string) (*big.Int, error) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("parsing %q as attoFIL: not a valid integer", s)
+	}
+	return v, nil
+}
+
+// FIL parses s as a decimal number of FIL (e.g. "1.5") and returns the
+// equivalent attoFIL amount, truncating any precision finer than 1 attoFIL.
+func FIL(s string) (*big.Int, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("parsing %q as FIL: not a valid decimal number", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt(attoPerFIL))
+	if !r.IsInt() {
+		r.Num().Quo(r.Num(), r.Denom())
+		return r.Num(), nil
+	}
+	return r.Num(), nil
+}
+
+// FormatAttoFIL returns v as a base-10 attoFIL string, suitable for storage.
+func FormatAttoFIL(v *big.Int) string {
+	if v == nil {
+		return "0"
+	}
+	return v.String()
+}
+
+// FormatFIL returns v (in attoFIL) formatted as a decimal FIL string.
+func FormatFIL(v *big.Int) string {
+	if v == nil {
+		v = big.NewInt(0)
+	}
+	r := new(big.Rat).SetFrac(v, attoPerFIL)
+	return r.FloatString(18)
+}
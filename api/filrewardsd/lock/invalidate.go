@@ -0,0 +1,82 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Invalidator lets replicas tell each other when they've written something
+// that makes another replica's in-memory cache stale. Messages are opaque
+// strings; filrewardsd encodes "<org key>|<dev key>|<reward type number>"
+// into them.
+type Invalidator interface {
+	Publish(ctx context.Context, msg string) error
+	// Subscribe returns a channel of incoming messages. It is closed when
+	// ctx is done.
+	Subscribe(ctx context.Context) (<-chan string, error)
+}
+
+// RedisInvalidator implements Invalidator over a Redis pub/sub channel.
+type RedisInvalidator struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisInvalidator returns an Invalidator that publishes to and
+// subscribes on the given Redis pub/sub channel.
+func NewRedisInvalidator(addr, channel string) *RedisInvalidator {
+	return &RedisInvalidator{client: redis.NewClient(&redis.Options{Addr: addr}), channel: channel}
+}
+
+func (i *RedisInvalidator) Publish(ctx context.Context, msg string) error {
+	if err := i.client.Publish(ctx, i.channel, msg).Err(); err != nil {
+		return fmt.Errorf("publishing invalidation on %s: %v", i.channel, err)
+	}
+	return nil
+}
+
+func (i *RedisInvalidator) Subscribe(ctx context.Context) (<-chan string, error) {
+	sub := i.client.Subscribe(ctx, i.channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		return nil, fmt.Errorf("subscribing to %s: %v", i.channel, err)
+	}
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case out <- m.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// NoopInvalidator implements Invalidator without any cross-process effect,
+// for tests and single-replica deployments.
+type NoopInvalidator struct{}
+
+func (NoopInvalidator) Publish(ctx context.Context, msg string) error { return nil }
+
+func (NoopInvalidator) Subscribe(ctx context.Context) (<-chan string, error) {
+	out := make(chan string)
+	go func() {
+		<-ctx.Done()
+		close(out)
+	}()
+	return out, nil
+}
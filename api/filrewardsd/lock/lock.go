@@ -0,0 +1,188 @@
+// Package lock provides a distributed, Redis-backed mutual exclusion lock
+// (Redlock-style: a unique token per acquisition, a CAS release, and a
+// background renewer), so that multiple filrewardsd replicas serialize
+// access to the same org's reward/claim state. An in-memory implementation
+// is also provided so tests (and single-replica deployments) don't need a
+// real Redis.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("filrewards/lock")
+
+// Lock represents a held lock. Callers must Release it.
+type Lock interface {
+	Release(ctx context.Context) error
+}
+
+// Locker acquires named, mutually exclusive locks with a TTL. Acquire blocks
+// until the lock is held or ctx is done.
+type Locker interface {
+	Acquire(ctx context.Context, key string, ttl time.Duration) (Lock, error)
+}
+
+// --- Redis-backed implementation ---
+
+const releaseScript = `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+else
+	return 0
+end
+`
+
+const renewScript = `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+else
+	return 0
+end
+`
+
+// RedisLocker implements Locker with SET key token NX PX ttl, a Lua
+// compare-and-delete release, and a background renewer that keeps the lock
+// alive for as long as it's held.
+type RedisLocker struct {
+	client      *redis.Client
+	retryDelay  time.Duration
+	renewPeriod func(ttl time.Duration) time.Duration
+}
+
+// NewRedisLocker returns a Locker backed by the Redis instance at addr.
+func NewRedisLocker(addr string) *RedisLocker {
+	return &RedisLocker{
+		client:     redis.NewClient(&redis.Options{Addr: addr}),
+		retryDelay: 50 * time.Millisecond,
+		renewPeriod: func(ttl time.Duration) time.Duration {
+			return ttl / 2
+		},
+	}
+}
+
+func (l *RedisLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (Lock, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("generating lock token: %v", err)
+	}
+	for {
+		ok, err := l.client.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			return nil, fmt.Errorf("acquiring lock %s: %v", key, err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(l.retryDelay):
+		}
+	}
+
+	rl := &redisLock{client: l.client, key: key, token: token, done: make(chan struct{})}
+	go rl.renewLoop(l.renewPeriod(ttl), ttl)
+	return rl, nil
+}
+
+type redisLock struct {
+	client *redis.Client
+	key    string
+	token  string
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (rl *redisLock) renewLoop(period, ttl time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rl.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), ttl)
+			res, err := rl.client.Eval(ctx, renewScript, []string{rl.key}, rl.token, ttl.Milliseconds()).Result()
+			cancel()
+			if err != nil {
+				log.Errorf("renewing lock %s: %v", rl.key, err)
+				continue
+			}
+			if n, ok := res.(int64); ok && n == 0 {
+				log.Errorf("lost lock %s to another holder during renewal", rl.key)
+				return
+			}
+		}
+	}
+}
+
+func (rl *redisLock) Release(ctx context.Context) error {
+	rl.once.Do(func() { close(rl.done) })
+	if err := rl.client.Eval(ctx, releaseScript, []string{rl.key}, rl.token).Err(); err != nil {
+		return fmt.Errorf("releasing lock %s: %v", rl.key, err)
+	}
+	return nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// --- In-memory implementation, for tests and single-replica deployments ---
+
+// InMemoryLocker implements Locker with a process-local, capacity-1 channel
+// per key used as a semaphore. It provides no cross-process guarantees and
+// exists so tests (and Config) can substitute it for a real Redis.
+type InMemoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// NewInMemoryLocker returns an in-memory Locker.
+func NewInMemoryLocker() *InMemoryLocker {
+	return &InMemoryLocker{locks: make(map[string]chan struct{})}
+}
+
+func (l *InMemoryLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (Lock, error) {
+	l.mu.Lock()
+	sem, ok := l.locks[key]
+	if !ok {
+		sem = make(chan struct{}, 1)
+		l.locks[key] = sem
+	}
+	l.mu.Unlock()
+
+	// A buffered channel send, rather than sync.Mutex.Lock, lets Acquire
+	// abandon a pending acquisition via ctx.Done() without leaving anything
+	// behind: there's no blocked goroutine to leak, and the key isn't left
+	// wedged for the next caller the way a Lock() call nobody unblocks
+	// would be.
+	select {
+	case sem <- struct{}{}:
+		return &memLock{sem: sem}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type memLock struct {
+	sem chan struct{}
+}
+
+func (ml *memLock) Release(ctx context.Context) error {
+	<-ml.sem
+	return nil
+}
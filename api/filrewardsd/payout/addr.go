@@ -0,0 +1,86 @@
+package payout
+
+import (
+	"bytes"
+	"encoding/base32"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// checksumSize is the length, in bytes, of the trailing checksum appended
+// to a protocol 1/2/3 address payload before it's base32-encoded.
+const checksumSize = 4
+
+// ValidateAddress checks that addr is a valid Filecoin address: a mainnet
+// ("f") or testnet ("t") network prefix, a protocol digit (0-4), a payload
+// whose shape matches that protocol, and — for protocols 1, 2, and 3 — a
+// blake2b checksum over the protocol byte and payload that matches the one
+// encoded into the address.
+func ValidateAddress(addr string) error {
+	if len(addr) < 3 {
+		return fmt.Errorf("address %q is too short", addr)
+	}
+	if addr[0] != 'f' && addr[0] != 't' {
+		return fmt.Errorf("address %q has unknown network prefix %q", addr, addr[0])
+	}
+	protocol := addr[1]
+	payload := addr[2:]
+	switch protocol {
+	case '0':
+		if _, err := strconv.ParseUint(payload, 10, 64); err != nil {
+			return fmt.Errorf("address %q has invalid protocol 0 payload: %v", addr, err)
+		}
+	case '1', '2', '3':
+		decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(upperCaseAddr(payload))
+		if err != nil {
+			return fmt.Errorf("address %q has invalid protocol %c payload: %v", addr, protocol, err)
+		}
+		if err := validateChecksum(protocol-'0', decoded); err != nil {
+			return fmt.Errorf("address %q failed checksum validation: %v", addr, err)
+		}
+	case '4':
+		// f4 (delegated) addresses encode a namespace id and a sub-address;
+		// just require a non-empty payload here.
+		if payload == "" {
+			return fmt.Errorf("address %q is missing a protocol 4 payload", addr)
+		}
+	default:
+		return fmt.Errorf("address %q has unknown protocol %q", addr, protocol)
+	}
+	return nil
+}
+
+// validateChecksum splits decoded into its payload and trailing checksum,
+// and reports an error if the checksum doesn't match a fresh blake2b-4sum
+// of (protocol, payload) — the same scheme lotus/go-address use to encode
+// addresses.
+func validateChecksum(protocol byte, decoded []byte) error {
+	if len(decoded) <= checksumSize {
+		return fmt.Errorf("payload too short to contain a checksum")
+	}
+	split := len(decoded) - checksumSize
+	payload, wantChecksum := decoded[:split], decoded[split:]
+
+	h, err := blake2b.New(checksumSize, nil)
+	if err != nil {
+		return fmt.Errorf("creating checksum hasher: %v", err)
+	}
+	h.Write([]byte{protocol})
+	h.Write(payload)
+	if !bytes.Equal(h.Sum(nil), wantChecksum) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+func upperCaseAddr(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	return string(b)
+}
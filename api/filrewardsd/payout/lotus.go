@@ -0,0 +1,109 @@
+package payout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// defaultHTTPTimeout bounds how long a single Lotus JSON-RPC call can block,
+// so a stalled or unresponsive node can't wedge the Reconciler's sweep or a
+// RetryPayout call.
+const defaultHTTPTimeout = 30 * time.Second
+
+// LotusPayer submits payouts by calling a Lotus node's JSON-RPC API
+// (MpoolPushMessage to submit, StateSearchMsg to confirm inclusion).
+type LotusPayer struct {
+	addr     string
+	token    string
+	fromAddr string
+	client   *http.Client
+}
+
+// NewLotusPayer returns a Payer that settles through the Lotus JSON-RPC
+// endpoint at addr, signing from fromAddr using token for auth.
+func NewLotusPayer(addr, token, fromAddr string) *LotusPayer {
+	return &LotusPayer{addr: addr, token: token, fromAddr: fromAddr, client: &http.Client{Timeout: defaultHTTPTimeout}}
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *LotusPayer) call(ctx context.Context, method string, params []interface{}, out interface{}) error {
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("marshaling rpc request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.addr, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building rpc request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling lotus %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	var rr rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
+		return fmt.Errorf("decoding lotus %s response: %v", method, err)
+	}
+	if rr.Error != nil {
+		return fmt.Errorf("lotus %s: %s", method, rr.Error.Message)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rr.Result, out); err != nil {
+			return fmt.Errorf("decoding lotus %s result: %v", method, err)
+		}
+	}
+	return nil
+}
+
+// Submit pushes a value transfer message from the payer's wallet to toAddr
+// via MpoolPushMessage and returns the resulting message CID.
+func (p *LotusPayer) Submit(ctx context.Context, toAddr string, amountAttoFIL *big.Int) (string, error) {
+	msg := map[string]interface{}{
+		"Version": 0,
+		"To":      toAddr,
+		"From":    p.fromAddr,
+		"Value":   amountAttoFIL.String(),
+		"Method":  0,
+	}
+	var result struct {
+		Cid struct {
+			Root string `json:"/"`
+		} `json:"CID"`
+	}
+	if err := p.call(ctx, "Filecoin.MpoolPushMessage", []interface{}{msg, nil}, &result); err != nil {
+		return "", err
+	}
+	return result.Cid.Root, nil
+}
+
+// SearchMessage reports whether cid has been included on chain by calling
+// StateSearchMsg; a nil result means the message has not yet landed.
+func (p *LotusPayer) SearchMessage(ctx context.Context, cid string) (bool, error) {
+	var result json.RawMessage
+	if err := p.call(ctx, "Filecoin.StateSearchMsg", []interface{}{map[string]string{"/": cid}}, &result); err != nil {
+		return false, err
+	}
+	return string(result) != "null", nil
+}
@@ -0,0 +1,65 @@
+package payout
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// ManualPayer records payouts to a CSV file for an operator to settle out of
+// band, rather than submitting directly to chain. SearchMessage always
+// reports the payout as landed once it has been appended to the export,
+// since confirmation happens manually.
+type ManualPayer struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewManualPayer returns a Payer that appends payout requests to the CSV
+// file at path, creating it (with a header row) if it does not exist.
+func NewManualPayer(path string) (*ManualPayer, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("creating manual payout export: %v", err)
+		}
+		w := csv.NewWriter(f)
+		if err := w.Write([]string{"ref", "to_addr", "amount_atto_fil", "created_at"}); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("writing manual payout export header: %v", err)
+		}
+		w.Flush()
+		f.Close()
+	}
+	return &ManualPayer{path: path}, nil
+}
+
+// Submit appends a row to the export and returns a reference in place of an
+// on-chain message CID.
+func (p *ManualPayer) Submit(ctx context.Context, toAddr string, amountAttoFIL *big.Int) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ref := fmt.Sprintf("manual-%d", time.Now().UnixNano())
+	f, err := os.OpenFile(p.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("opening manual payout export: %v", err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{ref, toAddr, amountAttoFIL.String(), time.Now().Format(time.RFC3339)}); err != nil {
+		return "", fmt.Errorf("appending manual payout export row: %v", err)
+	}
+	w.Flush()
+	return ref, w.Error()
+}
+
+// SearchMessage always reports true: a manually exported payout is
+// considered landed as soon as an operator has it in the export to settle.
+func (p *ManualPayer) SearchMessage(ctx context.Context, cid string) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,323 @@
+// Package payout drives settlement of approved claims to a destination
+// Filecoin wallet address. A Payer submits the on-chain message; the
+// Reconciler polls for inclusion and advances payout state, mirroring the
+// broadcaster/confirmer split used by EVM transaction managers.
+package payout
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/textileio/textile/v2/api/filrewardsd/filunits"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var log = logging.Logger("filrewards/payout")
+
+// State is the lifecycle of a single payout.
+type State string
+
+const (
+	StatePending State = "pending"
+	// StateSubmitting is a brief, internal-only state a payout holds while a
+	// Reconciler has claimed it and is calling Payer.Submit, so a concurrent
+	// claimant (the background sweep racing an explicit RetryPayout) can't
+	// submit the same payout twice.
+	StateSubmitting State = "submitting"
+	StateSubmitted  State = "submitted"
+	StateConfirmed  State = "confirmed"
+	StateFailed     State = "failed"
+)
+
+// Payout is the on-chain settlement record for a single claim.
+type Payout struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty"`
+	ClaimID primitive.ObjectID `bson:"claim_id"`
+	OrgKey  string             `bson:"org_key"`
+	ToAddr  string             `bson:"to_addr"`
+	// AmountAttoFIL is a base-10 attoFIL amount, stored as a string since it
+	// can exceed the range of an int32/int64. It lets the Reconciler's
+	// background sweep drive a pending payout through Payer.Submit without
+	// looking up its claim.
+	AmountAttoFIL string     `bson:"amount_atto_fil"`
+	Cid           string     `bson:"cid"`
+	State         State      `bson:"state"`
+	Attempts      int32      `bson:"attempts"`
+	LastError     string     `bson:"last_error"`
+	CreatedAt     time.Time  `bson:"created_at"`
+	ConfirmedAt   *time.Time `bson:"confirmed_at,omitempty"`
+}
+
+// Payer submits a payout to the chain and reports on its inclusion. It is
+// implemented by a Lotus JSON-RPC backed payer for real settlement, and a
+// manual/CSV export payer for operators who settle wallets out of band.
+type Payer interface {
+	// Submit broadcasts amountAttoFIL to toAddr and returns the message CID
+	// (or an export reference, for the manual payer).
+	Submit(ctx context.Context, toAddr string, amountAttoFIL *big.Int) (cid string, err error)
+	// SearchMessage reports whether cid has landed on chain. ok is false if
+	// the message has not yet been included.
+	SearchMessage(ctx context.Context, cid string) (ok bool, err error)
+}
+
+const collectionName = "payouts"
+
+// Store wraps the payouts mongo collection.
+type Store struct {
+	col *mongo.Collection
+}
+
+// NewStore returns a Store backed by db, creating its indexes.
+func NewStore(ctx context.Context, db *mongo.Database) (*Store, error) {
+	col := db.Collection(collectionName)
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{primitive.E{Key: "claim_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{primitive.E{Key: "org_key", Value: 1}}},
+		{Keys: bson.D{primitive.E{Key: "state", Value: 1}}},
+		{Keys: bson.D{primitive.E{Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating payout indexes: %v", err)
+	}
+	return &Store{col: col}, nil
+}
+
+// Create inserts a pending payout of amountAttoFIL for claimID, destined for
+// toAddr. It does not submit the payout; the Reconciler's background sweep
+// (or an explicit RetryPayout) drives pending payouts through Payer.Submit.
+func (s *Store) Create(ctx context.Context, claimID primitive.ObjectID, orgKey, toAddr, amountAttoFIL string) (*Payout, error) {
+	p := &Payout{
+		ID:            primitive.NewObjectID(),
+		ClaimID:       claimID,
+		OrgKey:        orgKey,
+		ToAddr:        toAddr,
+		AmountAttoFIL: amountAttoFIL,
+		State:         StatePending,
+		CreatedAt:     time.Now(),
+	}
+	if _, err := s.col.InsertOne(ctx, p); err != nil {
+		return nil, fmt.Errorf("inserting payout: %v", err)
+	}
+	return p, nil
+}
+
+// Get returns the payout with the given id.
+func (s *Store) Get(ctx context.Context, id primitive.ObjectID) (*Payout, error) {
+	res := s.col.FindOne(ctx, bson.M{"_id": id})
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+	var p Payout
+	if err := res.Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// List returns payouts matching filter, sorted by (created_at, _id) for a
+// stable order (newest first, unless ascending), optionally resuming after
+// (startAt, startAfterID) — the same (created_at, _id) keyset cursor
+// ListRewards/ListClaims use, with startAfterID breaking ties among
+// payouts created in the same instant. It also reports whether more
+// results exist beyond limit and, if so, the cursor to resume from.
+func (s *Store) List(ctx context.Context, filter bson.M, limit int64, ascending bool, startAt *time.Time, startAfterID *primitive.ObjectID) ([]Payout, bool, *time.Time, *primitive.ObjectID, error) {
+	sort := -1
+	comp := "$lt"
+	if ascending {
+		sort = 1
+		comp = "$gt"
+	}
+	if startAt != nil {
+		if startAfterID != nil {
+			filter["$or"] = bson.A{
+				bson.M{"created_at": bson.M{comp: *startAt}},
+				bson.M{"created_at": *startAt, "_id": bson.M{comp: *startAfterID}},
+			}
+		} else {
+			filter["created_at"] = bson.M{comp: *startAt}
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "created_at", Value: sort}, primitive.E{Key: "_id", Value: sort}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := s.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, false, nil, nil, fmt.Errorf("querying payouts: %v", err)
+	}
+	defer cursor.Close(ctx)
+	var payouts []Payout
+	if err := cursor.All(ctx, &payouts); err != nil {
+		return nil, false, nil, nil, fmt.Errorf("decoding payout query results: %v", err)
+	}
+
+	more := false
+	var moreStartAt *time.Time
+	var moreStartAfterID *primitive.ObjectID
+	if len(payouts) > 0 {
+		last := payouts[len(payouts)-1]
+		delete(filter, "created_at")
+		filter["$or"] = bson.A{
+			bson.M{"created_at": bson.M{comp: last.CreatedAt}},
+			bson.M{"created_at": last.CreatedAt, "_id": bson.M{comp: last.ID}},
+		}
+		res := s.col.FindOne(ctx, filter)
+		if res.Err() != nil && res.Err() != mongo.ErrNoDocuments {
+			return nil, false, nil, nil, fmt.Errorf("checking for more payouts: %v", res.Err())
+		}
+		if res.Err() != mongo.ErrNoDocuments {
+			more = true
+			moreStartAt = &last.CreatedAt
+			moreStartAfterID = &last.ID
+		}
+	}
+	return payouts, more, moreStartAt, moreStartAfterID, nil
+}
+
+func (s *Store) setState(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	_, err := s.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": update})
+	return err
+}
+
+// claimForSubmit atomically transitions id from one of fromStates to
+// StateSubmitting, so only one caller ever drives a given payout through
+// Payer.Submit at a time. ok is false if id is no longer in fromStates —
+// another caller (the background sweep, or an explicit RetryPayout) already
+// claimed it.
+func (s *Store) claimForSubmit(ctx context.Context, id primitive.ObjectID, fromStates ...State) (bool, error) {
+	res, err := s.col.UpdateOne(ctx,
+		bson.M{"_id": id, "state": bson.M{"$in": fromStates}},
+		bson.M{"$set": bson.M{"state": StateSubmitting}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("claiming payout for submit: %v", err)
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// Reconciler submits pending payouts through its Payer, and polls submitted
+// payouts for on-chain inclusion and advances them to confirmed. A payout
+// becomes failed only through Submit errors; a submitted payout stays
+// submitted until SearchMessage reports it landed.
+type Reconciler struct {
+	store    *Store
+	payer    Payer
+	interval time.Duration
+	maxTries int32
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewReconciler returns a Reconciler that polls at interval and marks a
+// payout failed once maxTries Payer.Submit attempts have failed.
+// SearchMessage misses are not counted.
+func NewReconciler(store *Store, payer Payer, interval time.Duration, maxTries int32) *Reconciler {
+	return &Reconciler{store: store, payer: payer, interval: interval, maxTries: maxTries}
+}
+
+// Start runs the reconciliation loop in a background goroutine until Stop is
+// called or ctx is canceled.
+func (r *Reconciler) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.reconcileOnce(ctx); err != nil {
+					log.Errorf("reconciling payouts: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop cancels the reconciliation loop and waits for it to exit.
+func (r *Reconciler) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+func (r *Reconciler) reconcileOnce(ctx context.Context) error {
+	pending, _, _, _, err := r.store.List(ctx, bson.M{"state": StatePending}, 0, false, nil, nil)
+	if err != nil {
+		return fmt.Errorf("listing pending payouts: %v", err)
+	}
+	for _, p := range pending {
+		amount, err := filunits.AttoFIL(p.AmountAttoFIL)
+		if err != nil {
+			log.Errorf("parsing amount for pending payout %s: %v", p.ID.Hex(), err)
+			continue
+		}
+		if _, err := r.Submit(ctx, &p, amount); err != nil {
+			log.Errorf("submitting pending payout %s: %v", p.ID.Hex(), err)
+		}
+	}
+
+	submitted, _, _, _, err := r.store.List(ctx, bson.M{"state": StateSubmitted}, 0, false, nil, nil)
+	if err != nil {
+		return fmt.Errorf("listing submitted payouts: %v", err)
+	}
+	for _, p := range submitted {
+		ok, err := r.payer.SearchMessage(ctx, p.Cid)
+		if err != nil {
+			log.Errorf("searching message %s for payout %s: %v", p.Cid, p.ID.Hex(), err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		now := time.Now()
+		if err := r.store.setState(ctx, p.ID, bson.M{"state": StateConfirmed, "confirmed_at": now}); err != nil {
+			log.Errorf("confirming payout %s: %v", p.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// Submit claims p — atomically transitioning it from pending/failed to
+// submitting, so a concurrent claimant can't submit it twice — then drives
+// it through the configured Payer, transitioning to submitted on success or
+// back to pending (or failed, once maxTries is exceeded) on error. claimed
+// is false if p was already claimed by another caller, e.g. the background
+// sweep beating an explicit RetryPayout to it.
+func (r *Reconciler) Submit(ctx context.Context, p *Payout, amountAttoFIL *big.Int) (claimed bool, err error) {
+	claimed, err = r.store.claimForSubmit(ctx, p.ID, StatePending, StateFailed)
+	if err != nil {
+		return false, fmt.Errorf("claiming payout: %v", err)
+	}
+	if !claimed {
+		return false, nil
+	}
+
+	cid, err := r.payer.Submit(ctx, p.ToAddr, amountAttoFIL)
+	if err != nil {
+		attempts := p.Attempts + 1
+		update := bson.M{"attempts": attempts, "last_error": err.Error(), "state": StatePending}
+		if attempts >= r.maxTries {
+			update["state"] = StateFailed
+		}
+		if setErr := r.store.setState(ctx, p.ID, update); setErr != nil {
+			log.Errorf("recording payout submit failure for %s: %v", p.ID.Hex(), setErr)
+		}
+		return true, fmt.Errorf("submitting payout: %v", err)
+	}
+	return true, r.store.setState(ctx, p.ID, bson.M{"state": StateSubmitted, "cid": cid, "attempts": p.Attempts + 1})
+}
@@ -0,0 +1,66 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const countersCollectionName = "filreward_counters"
+
+// Counters backs a Rule's min_count condition: a simple per-key increment
+// counter, persisted so it survives restarts and is shared across replicas.
+type Counters struct {
+	col *mongo.Collection
+}
+
+// NewCounters returns a Counters backed by db, creating its index.
+func NewCounters(ctx context.Context, db *mongo.Database) (*Counters, error) {
+	col := db.Collection(countersCollectionName)
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{primitive.E{Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating filreward_counters index: %v", err)
+	}
+	return &Counters{col: col}, nil
+}
+
+// Increment atomically bumps key's counter and returns its new value.
+func (c *Counters) Increment(ctx context.Context, key string) (int32, error) {
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	res := c.col.FindOneAndUpdate(ctx, bson.M{"key": key}, bson.M{"$inc": bson.M{"count": int32(1)}}, opts)
+	if res.Err() != nil {
+		return 0, res.Err()
+	}
+	var doc struct {
+		Count int32 `bson:"count"`
+	}
+	if err := res.Decode(&doc); err != nil {
+		return 0, err
+	}
+	return doc.Count, nil
+}
+
+// Get returns key's current counter value without incrementing it.
+func (c *Counters) Get(ctx context.Context, key string) (int32, error) {
+	res := c.col.FindOne(ctx, bson.M{"key": key})
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, res.Err()
+	}
+	var doc struct {
+		Count int32 `bson:"count"`
+	}
+	if err := res.Decode(&doc); err != nil {
+		return 0, err
+	}
+	return doc.Count, nil
+}
@@ -0,0 +1,192 @@
+// Package rules loads the reward-granting rules filrewardsd evaluates
+// against incoming analytics events, replacing what used to be a hardcoded
+// switch statement with a YAML/JSON file an operator can edit without a
+// redeploy.
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	analyticspb "github.com/textileio/textile/v2/api/analyticsd/pb"
+	pb "github.com/textileio/textile/v2/api/filrewardsd/pb"
+	"gopkg.in/yaml.v2"
+)
+
+// OneShotScope controls which key a rule's one_shot_per applies to.
+type OneShotScope string
+
+const (
+	OneShotOrg  OneShotScope = "org"
+	OneShotDev  OneShotScope = "dev"
+	OneShotBoth OneShotScope = "both"
+)
+
+// Match describes the conditions under which a Rule fires.
+type Match struct {
+	Event    string `yaml:"event" json:"event"`
+	OrgTier  string `yaml:"org_tier,omitempty" json:"org_tier,omitempty"`
+	DevPlan  string `yaml:"dev_plan,omitempty" json:"dev_plan,omitempty"`
+	MinCount int32  `yaml:"min_count,omitempty" json:"min_count,omitempty"`
+}
+
+// Grant describes the reward a matching Rule produces. A rule can only ever
+// grant its reward type once per the key(s) OneShotPer scopes to — the
+// unique (org_key,type)/(dev_key,type) mongo indexes ProcessAnalyticsEvent
+// inserts rewards under enforce that, so there is deliberately no
+// repeat-with-a-limit ("cap") or rate-limiting ("cooldown") concept here.
+type Grant struct {
+	Type       string       `yaml:"type" json:"type"`
+	Factor     int32        `yaml:"factor" json:"factor"`
+	OneShotPer OneShotScope `yaml:"one_shot_per" json:"one_shot_per"`
+}
+
+// Rule is a single {match, grant} pair read from the rules file.
+type Rule struct {
+	Match Match `yaml:"match" json:"match"`
+	Grant Grant `yaml:"grant" json:"grant"`
+}
+
+// ParsedEvent carries the resolved analyticspb.Event and pb.RewardType for a
+// Rule, computed once at load time so Evaluate doesn't re-parse strings on
+// every call.
+type ParsedEvent struct {
+	Rule       Rule
+	Event      analyticspb.Event
+	RewardType pb.RewardType
+}
+
+// Evaluator holds the currently loaded rule set and can be hot-reloaded.
+type Evaluator struct {
+	mu    sync.RWMutex
+	path  string
+	rules []ParsedEvent
+}
+
+// NewEvaluator loads rules from path and returns an Evaluator.
+func NewEvaluator(path string) (*Evaluator, error) {
+	e := &Evaluator{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload re-reads the rules file from disk, atomically swapping in the new
+// rule set only if it parses successfully. Intended to be wired to SIGHUP.
+func (e *Evaluator) Reload() error {
+	rules, err := load(e.path)
+	if err != nil {
+		return fmt.Errorf("loading rules from %s: %v", e.path, err)
+	}
+	parsed := make([]ParsedEvent, len(rules))
+	for i, r := range rules {
+		event, ok := analyticspb.Event_value[r.Match.Event]
+		if !ok {
+			return fmt.Errorf("rule %d: unknown analytics event %q", i, r.Match.Event)
+		}
+		rewardType, ok := pb.RewardType_value[r.Grant.Type]
+		if !ok {
+			return fmt.Errorf("rule %d: unknown reward type %q", i, r.Grant.Type)
+		}
+		parsed[i] = ParsedEvent{Rule: r, Event: analyticspb.Event(event), RewardType: pb.RewardType(rewardType)}
+	}
+
+	e.mu.Lock()
+	e.rules = parsed
+	e.mu.Unlock()
+	return nil
+}
+
+// Rules returns a snapshot of the currently loaded rules, for ListRules.
+func (e *Evaluator) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]Rule, len(e.rules))
+	for i, p := range e.rules {
+		out[i] = p.Rule
+	}
+	return out
+}
+
+// CounterLookup resolves the current count for a per-key counter, used to
+// satisfy a rule's min_count condition (e.g. "grant after the 3rd bucket
+// archive"). Implementations increment the counter as part of the lookup.
+type CounterLookup func(ctx context.Context, key string) (int32, error)
+
+// Candidate is a reward Evaluate has decided should be granted.
+type Candidate struct {
+	Rule       Rule
+	RewardType pb.RewardType
+}
+
+// Evaluate returns the candidates produced by matching req.AnalyticsEvent,
+// req.OrgTier, and req.DevPlan against the loaded rules, consulting counts
+// for any rule with a min_count condition.
+func (e *Evaluator) Evaluate(ctx context.Context, req *pb.ProcessAnalyticsEventRequest, counters CounterLookup) ([]Candidate, error) {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	var candidates []Candidate
+	for _, p := range rules {
+		if p.Event != req.AnalyticsEvent {
+			continue
+		}
+		if p.Rule.Match.OrgTier != "" && p.Rule.Match.OrgTier != req.OrgTier {
+			continue
+		}
+		if p.Rule.Match.DevPlan != "" && p.Rule.Match.DevPlan != req.DevPlan {
+			continue
+		}
+		if p.Rule.Match.MinCount > 0 {
+			count, err := counters(ctx, counterKey(req, p))
+			if err != nil {
+				return nil, fmt.Errorf("looking up counter: %v", err)
+			}
+			if count < p.Rule.Match.MinCount {
+				continue
+			}
+		}
+		candidates = append(candidates, Candidate{Rule: p.Rule, RewardType: p.RewardType})
+	}
+	return candidates, nil
+}
+
+// counterKey scopes a rule's min_count counter by event, the dev key for a
+// dev-scoped one_shot_per (the org key otherwise, "both" included), and the
+// rule's own grant type — so two
+// rules matching the same event (e.g. a base rule and a tier-specific bonus
+// rule) don't share a counter and inflate each other's count.
+func counterKey(req *pb.ProcessAnalyticsEventRequest, p ParsedEvent) string {
+	switch p.Rule.Grant.OneShotPer {
+	case OneShotDev:
+		return fmt.Sprintf("%s:%s:%s", req.DevKey, p.Rule.Match.Event, p.Rule.Grant.Type)
+	default:
+		return fmt.Sprintf("%s:%s:%s", req.OrgKey, p.Rule.Match.Event, p.Rule.Grant.Type)
+	}
+}
+
+func load(path string) ([]Rule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(b, &rules); err != nil {
+			return nil, fmt.Errorf("decoding json rules: %v", err)
+		}
+	default:
+		if err := yaml.Unmarshal(b, &rules); err != nil {
+			return nil, fmt.Errorf("decoding yaml rules: %v", err)
+		}
+	}
+	return rules, nil
+}
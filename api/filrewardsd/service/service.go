@@ -4,15 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/big"
 	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
-	nutil "github.com/textileio/go-threads/net/util"
 	"github.com/textileio/go-threads/util"
 	analytics "github.com/textileio/textile/v2/api/analyticsd/client"
 	analyticspb "github.com/textileio/textile/v2/api/analyticsd/pb"
+	"github.com/textileio/textile/v2/api/filrewardsd/filunits"
+	"github.com/textileio/textile/v2/api/filrewardsd/lock"
+	"github.com/textileio/textile/v2/api/filrewardsd/payout"
 	pb "github.com/textileio/textile/v2/api/filrewardsd/pb"
+	"github.com/textileio/textile/v2/api/filrewardsd/rules"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -24,71 +34,113 @@ import (
 )
 
 const (
-	rewardsCollectionName = "filrewards"
-	claimsCollectionName  = "filclaims"
+	rewardsCollectionName         = "filrewards"
+	claimsCollectionName          = "filclaims"
+	processedEventsCollectionName = "filreward_processed_events"
+
+	defaultIdempotencyKeyTTL = 7 * 24 * time.Hour
 )
 
 var log = logging.Logger("filrewards")
 
-var rewardTypeMeta = map[pb.RewardType]meta{
-	pb.RewardType_REWARD_TYPE_FIRST_KEY_ACCOUNT_CREATED:    {factor: 3},
-	pb.RewardType_REWARD_TYPE_FIRST_KEY_USER_CREATED:       {factor: 1},
-	pb.RewardType_REWARD_TYPE_FIRST_ORG_CREATED:            {factor: 3},
-	pb.RewardType_REWARD_TYPE_INITIAL_BILLING_SETUP:        {factor: 1},
-	pb.RewardType_REWARD_TYPE_FIRST_BUCKET_CREATED:         {factor: 2},
-	pb.RewardType_REWARD_TYPE_FIRST_BUCKET_ARCHIVE_CREATED: {factor: 2},
-	pb.RewardType_REWARD_TYPE_FIRST_MAILBOX_CREATED:        {factor: 1},
-	pb.RewardType_REWARD_TYPE_FIRST_THREAD_DB_CREATED:      {factor: 1},
-}
-
-type meta struct {
-	factor int32
-}
-
 type reward struct {
-	OrgKey            string        `bson:"org_key"`
-	DevKey            string        `bson:"dev_key"`
-	Type              pb.RewardType `bson:"type"`
-	Factor            int32         `bson:"factor"`
-	BaseAttoFILReward int32         `bson:"base_atto_fil_reward"`
-	CreatedAt         time.Time     `bson:"created_at"`
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	OrgKey string             `bson:"org_key"`
+	DevKey string             `bson:"dev_key"`
+	Type   pb.RewardType      `bson:"type"`
+	Factor int32              `bson:"factor"`
+	// BaseAttoFILReward is a base-10 attoFIL amount, stored as a string since
+	// it can exceed the range of an int32/int64.
+	BaseAttoFILReward string    `bson:"base_atto_fil_reward"`
+	CreatedAt         time.Time `bson:"created_at"`
 }
 
 type claim struct {
-	OrgKey    string    `bson:"org_key"`
-	ClaimedBy string    `bson:"claimed_by"`
-	Amount    int32     `bson:"amount"`
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	OrgKey    string             `bson:"org_key"`
+	ClaimedBy string             `bson:"claimed_by"`
+	// Amount is a base-10 attoFIL amount, stored as a string since it can
+	// exceed the range of an int32/int64.
+	Amount    string    `bson:"amount"`
 	CreatedAt time.Time `bson:"created_at"`
 }
 
-type orgKeyLock string
-
-func (l orgKeyLock) Key() string {
-	return string(l)
+// processedEvent records that a ProcessAnalyticsEvent call with a given
+// idempotency key has already run to completion, along with the rewards it
+// granted, so a retried or replayed call with the same key can return the
+// original result instead of re-evaluating rules and re-granting rewards.
+// CreatedAt backs a TTL index, since idempotency keys only need to survive
+// long enough to cover realistic retry/replay windows.
+type processedEvent struct {
+	IdempotencyKey string    `bson:"idempotency_key"`
+	Rewards        []*reward `bson:"rewards,omitempty"`
+	CreatedAt      time.Time `bson:"created_at"`
 }
 
-var _ nutil.SemaphoreKey = (*orgKeyLock)(nil)
-
 var _ pb.FilRewardsServiceServer = (*Service)(nil)
 
+// orgLockKey namespaces an org's distributed lock key so it can't collide
+// with keys other subsystems might take out on the same Redis.
+func orgLockKey(orgKey string) string {
+	return "filrewards:org-lock:" + orgKey
+}
+
 type Service struct {
-	rewardsCol        *mongo.Collection
-	claimsCol         *mongo.Collection
-	ac                *analytics.Client
-	rewardsCacheOrg   map[string]map[pb.RewardType]struct{}
-	rewardsCacheDev   map[string]map[pb.RewardType]struct{}
-	baseAttoFILReward int32
-	server            *grpc.Server
-	semaphores        *nutil.SemaphorePool
+	rewardsCol         *mongo.Collection
+	claimsCol          *mongo.Collection
+	processedEventsCol *mongo.Collection
+	ac                 *analytics.Client
+	rewardsCacheOrg    map[string]map[pb.RewardType]struct{}
+	rewardsCacheDev    map[string]map[pb.RewardType]struct{}
+	rewardsCacheMu     sync.Mutex
+	baseAttoFILReward  *big.Int
+	server             *grpc.Server
+	locker             lock.Locker
+	lockTTL            time.Duration
+	invalidator        lock.Invalidator
+	payouts            *payout.Store
+	payer              payout.Payer
+	reconciler         *payout.Reconciler
+	rules              *rules.Evaluator
+	counters           *rules.Counters
+	sighup             chan os.Signal
 }
 
 type Config struct {
-	Listener          net.Listener
-	MongoUri          string
-	MongoDbName       string
-	AnalyticsAddr     string
-	BaseAttoFILReward int32
+	Listener      net.Listener
+	MongoUri      string
+	MongoDbName   string
+	AnalyticsAddr string
+	// BaseAttoFILReward is a base-10 attoFIL amount, e.g. as produced by
+	// filunits.AttoFIL or filunits.FIL.
+	BaseAttoFILReward string
 	Debug             bool
+
+	// LotusAddr and LotusToken configure a Lotus JSON-RPC backed Payer. If
+	// LotusAddr is empty, ManualPayoutExportPath is used instead.
+	LotusAddr               string
+	LotusToken              string
+	LotusFromAddr           string
+	ManualPayoutExportPath  string
+	PayoutReconcileInterval time.Duration
+	PayoutMaxAttempts       int32
+
+	// RulesPath is the YAML/JSON file of reward-granting rules, re-read on
+	// SIGHUP.
+	RulesPath string
+
+	// IdempotencyKeyTTL bounds how long a ProcessAnalyticsEventRequest's
+	// idempotency_key is remembered before it's eligible for reuse. Defaults
+	// to defaultIdempotencyKeyTTL.
+	IdempotencyKeyTTL time.Duration
+
+	// RedisAddr backs the default distributed Locker and Invalidator. Locker
+	// and Invalidator can be set directly instead (e.g. by tests, to
+	// substitute in-memory/no-op implementations).
+	RedisAddr   string
+	Locker      lock.Locker
+	Invalidator lock.Invalidator
+	LockTTL     time.Duration
 }
 
 func New(ctx context.Context, config Config) (*Service, error) {
@@ -107,6 +159,27 @@ func New(ctx context.Context, config Config) (*Service, error) {
 	db := client.Database(config.MongoDbName)
 	rewardsCol := db.Collection(rewardsCollectionName)
 	claimsCol := db.Collection(claimsCollectionName)
+	processedEventsCol := db.Collection(processedEventsCollectionName)
+
+	idempotencyKeyTTL := config.IdempotencyKeyTTL
+	if idempotencyKeyTTL == 0 {
+		idempotencyKeyTTL = defaultIdempotencyKeyTTL
+	}
+	ttlSeconds := int32(idempotencyKeyTTL.Seconds())
+	_, err = processedEventsCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{primitive.E{Key: "idempotency_key", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"idempotency_key": bson.M{"$gt": ""}}),
+		},
+		{
+			Keys:    bson.D{primitive.E{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(ttlSeconds),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating processed event indexes: %v", err)
+	}
+
 	_, err = rewardsCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{
 			Keys:    bson.D{primitive.E{Key: "org_key", Value: 1}, primitive.E{Key: "type", Value: 1}},
@@ -133,6 +206,15 @@ func New(ctx context.Context, config Config) (*Service, error) {
 		return nil, fmt.Errorf("creating indexes: %v", err)
 	}
 
+	if err := migrateMonetaryFields(ctx, rewardsCol, claimsCol); err != nil {
+		return nil, fmt.Errorf("migrating monetary fields: %v", err)
+	}
+
+	baseAttoFILReward, err := filunits.AttoFIL(config.BaseAttoFILReward)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base atto fil reward: %v", err)
+	}
+
 	// Populate caches.
 	opts := options.Find()
 	cursor, err := rewardsCol.Find(ctx, bson.M{}, opts)
@@ -156,15 +238,41 @@ func New(ctx context.Context, config Config) (*Service, error) {
 		return nil, fmt.Errorf("iterating cursor while building cache: %v", err)
 	}
 
+	lockTTL := config.LockTTL
+	if lockTTL == 0 {
+		lockTTL = 10 * time.Second
+	}
+
+	locker := config.Locker
+	if locker == nil {
+		if config.RedisAddr == "" {
+			return nil, fmt.Errorf("must provide a Locker or a RedisAddr")
+		}
+		locker = lock.NewRedisLocker(config.RedisAddr)
+	}
+	invalidator := config.Invalidator
+	if invalidator == nil {
+		if config.RedisAddr != "" {
+			invalidator = lock.NewRedisInvalidator(config.RedisAddr, "filrewards:invalidate")
+		} else {
+			invalidator = lock.NoopInvalidator{}
+		}
+	}
+
 	s := &Service{
-		rewardsCol:        rewardsCol,
-		claimsCol:         claimsCol,
-		rewardsCacheOrg:   cacheOrg,
-		rewardsCacheDev:   cacheDev,
-		baseAttoFILReward: config.BaseAttoFILReward,
-		semaphores:        nutil.NewSemaphorePool(1),
+		rewardsCol:         rewardsCol,
+		claimsCol:          claimsCol,
+		processedEventsCol: processedEventsCol,
+		rewardsCacheOrg:    cacheOrg,
+		rewardsCacheDev:    cacheDev,
+		baseAttoFILReward:  baseAttoFILReward,
+		locker:             locker,
+		lockTTL:            lockTTL,
+		invalidator:        invalidator,
 	}
 
+	go s.watchInvalidations(context.Background())
+
 	if config.AnalyticsAddr != "" {
 		s.ac, err = analytics.New(config.AnalyticsAddr)
 		if err != nil {
@@ -172,6 +280,49 @@ func New(ctx context.Context, config Config) (*Service, error) {
 		}
 	}
 
+	s.rules, err = rules.NewEvaluator(config.RulesPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading reward rules: %v", err)
+	}
+	s.counters, err = rules.NewCounters(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("creating reward counters: %v", err)
+	}
+	s.sighup = make(chan os.Signal, 1)
+	signal.Notify(s.sighup, syscall.SIGHUP)
+	go func() {
+		for range s.sighup {
+			if err := s.rules.Reload(); err != nil {
+				log.Errorf("reloading rules on SIGHUP: %v", err)
+				continue
+			}
+			log.Info("reloaded reward rules")
+		}
+	}()
+
+	s.payouts, err = payout.NewStore(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("creating payout store: %v", err)
+	}
+	if config.LotusAddr != "" {
+		s.payer = payout.NewLotusPayer(config.LotusAddr, config.LotusToken, config.LotusFromAddr)
+	} else {
+		s.payer, err = payout.NewManualPayer(config.ManualPayoutExportPath)
+		if err != nil {
+			return nil, fmt.Errorf("creating manual payout payer: %v", err)
+		}
+	}
+	reconcileInterval := config.PayoutReconcileInterval
+	if reconcileInterval == 0 {
+		reconcileInterval = time.Minute
+	}
+	maxAttempts := config.PayoutMaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 5
+	}
+	s.reconciler = payout.NewReconciler(s.payouts, s.payer, reconcileInterval, maxAttempts)
+	s.reconciler.Start(context.Background())
+
 	s.server = grpc.NewServer()
 	go func() {
 		pb.RegisterFilRewardsServiceServer(s.server, s)
@@ -191,83 +342,295 @@ func (s *Service) ProcessAnalyticsEvent(ctx context.Context, req *pb.ProcessAnal
 		return nil, status.Error(codes.InvalidArgument, "must provide analytics event")
 	}
 
-	lck := s.semaphores.Get(orgKeyLock(req.OrgKey))
-	lck.Acquire()
-	defer lck.Release()
-
-	t := pb.RewardType_REWARD_TYPE_UNSPECIFIED
-	switch req.AnalyticsEvent {
-	case analyticspb.Event_EVENT_KEY_ACCOUNT_CREATED:
-		t = pb.RewardType_REWARD_TYPE_FIRST_KEY_ACCOUNT_CREATED
-	case analyticspb.Event_EVENT_KEY_USER_CREATED:
-		t = pb.RewardType_REWARD_TYPE_FIRST_KEY_USER_CREATED
-	case analyticspb.Event_EVENT_ORG_CREATED:
-		t = pb.RewardType_REWARD_TYPE_FIRST_ORG_CREATED
-	case analyticspb.Event_EVENT_BILLING_SETUP:
-		t = pb.RewardType_REWARD_TYPE_INITIAL_BILLING_SETUP
-	case analyticspb.Event_EVENT_BUCKET_CREATED:
-		t = pb.RewardType_REWARD_TYPE_FIRST_BUCKET_CREATED
-	case analyticspb.Event_EVENT_BUCKET_ARCHIVE_CREATED:
-		t = pb.RewardType_REWARD_TYPE_FIRST_BUCKET_ARCHIVE_CREATED
-	case analyticspb.Event_EVENT_MAILBOX_CREATED:
-		t = pb.RewardType_REWARD_TYPE_FIRST_MAILBOX_CREATED
-	case analyticspb.Event_EVENT_THREAD_DB_CREATED:
-		t = pb.RewardType_REWARD_TYPE_FIRST_THREAD_DB_CREATED
-	}
-	if t == pb.RewardType_REWARD_TYPE_UNSPECIFIED {
-		// It is normal to get an analytics event we aren't interested in, so just return an empty result and no error.
+	if req.IdempotencyKey != "" {
+		// This is a fast-path check only: it lets an already-processed retry
+		// or replay skip rule evaluation (and the counter increments that
+		// come with it) entirely. The actual exactly-once guarantee comes
+		// from the unique index on idempotency_key, enforced below when the
+		// processed-event record is inserted alongside the granted rewards.
+		pe, err := s.getProcessedEvent(ctx, req.IdempotencyKey)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, status.Errorf(codes.Internal, "checking idempotency key: %v", err)
+		}
+		if err == nil {
+			return &pb.ProcessAnalyticsEventResponse{Rewards: toPbRewards(pe.Rewards)}, nil
+		}
+	}
+
+	lck, err := s.locker.Acquire(ctx, orgLockKey(req.OrgKey), s.lockTTL)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "acquiring org lock: %v", err)
+	}
+	defer releaseLock(lck)
+
+	candidates, err := s.rules.Evaluate(ctx, req, s.counters.Increment)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "evaluating reward rules: %v", err)
+	}
+	if len(candidates) == 0 {
+		// It is normal to get an analytics event no rule grants a reward
+		// for, so just return an empty result and no error. Still record the
+		// idempotency marker: s.rules.Evaluate already incremented any
+		// min_count counters the event's rules consult, so a retry or
+		// replay with the same idempotency_key must hit the fast-path check
+		// above instead of evaluating (and incrementing) again.
+		if req.IdempotencyKey != "" {
+			if _, err := s.processedEventsCol.InsertOne(ctx, &processedEvent{
+				IdempotencyKey: req.IdempotencyKey,
+				CreatedAt:      time.Now(),
+			}); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return nil, status.Errorf(codes.Internal, "recording processed event: %v", err)
+			}
+		}
 		return &pb.ProcessAnalyticsEventResponse{}, nil
 	}
 
+	s.rewardsCacheMu.Lock()
 	ensureKeyRewardCache(s.rewardsCacheOrg, req.OrgKey)
 	ensureKeyRewardCache(s.rewardsCacheDev, req.DevKey)
 
-	if _, exists := s.rewardsCacheOrg[req.OrgKey][t]; exists {
-		// This reward is already granted to the Org so bail.
-		return &pb.ProcessAnalyticsEventResponse{}, nil
+	var toInsert []*reward
+	for _, c := range candidates {
+		if alreadyGranted(s.rewardsCacheOrg, s.rewardsCacheDev, req, c) {
+			continue
+		}
+		toInsert = append(toInsert, &reward{
+			ID:                primitive.NewObjectID(),
+			OrgKey:            req.OrgKey,
+			DevKey:            req.DevKey,
+			Type:              c.RewardType,
+			Factor:            c.Rule.Grant.Factor,
+			BaseAttoFILReward: filunits.FormatAttoFIL(s.baseAttoFILReward),
+			CreatedAt:         time.Now(),
+		})
+	}
+	s.rewardsCacheMu.Unlock()
+
+	// The local cache above is only a fast-path hint; the unique
+	// (org_key,type)/(dev_key,type) indexes are the source of truth, so a
+	// peer replica racing us to grant the same reward just looks like a
+	// duplicate-key error here, not a correctness problem.
+	insertGranted := func(sessCtx context.Context) ([]*reward, error) {
+		var granted []*reward
+		for _, r := range toInsert {
+			if _, err := s.rewardsCol.InsertOne(sessCtx, r); err != nil {
+				if mongo.IsDuplicateKeyError(err) {
+					continue
+				}
+				return nil, err
+			}
+			granted = append(granted, r)
+		}
+		return granted, nil
+	}
+
+	// Always insert through a transaction, even with no idempotency key: a
+	// rule set can grant more than one reward for a single event, and
+	// without a transaction a mid-loop error in insertGranted would leave
+	// whichever rewards it already inserted permanently committed while the
+	// RPC itself fails.
+	session, err := s.rewardsCol.Database().Client().StartSession()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "starting reward insert session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	var granted []*reward
+	_, txErr := session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		g, err := insertGranted(sessCtx)
+		if err != nil {
+			return nil, err
+		}
+		granted = g
+		if req.IdempotencyKey == "" {
+			return nil, nil
+		}
+		_, err = s.processedEventsCol.InsertOne(sessCtx, &processedEvent{
+			IdempotencyKey: req.IdempotencyKey,
+			Rewards:        granted,
+			CreatedAt:      time.Now(),
+		})
+		return nil, err
+	})
+	if txErr != nil {
+		if req.IdempotencyKey != "" && mongo.IsDuplicateKeyError(txErr) {
+			// Lost a race with a peer replica processing the same
+			// idempotency key; return its result instead of ours.
+			pe, err := s.getProcessedEvent(ctx, req.IdempotencyKey)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "loading previously processed event: %v", err)
+			}
+			return &pb.ProcessAnalyticsEventResponse{Rewards: toPbRewards(pe.Rewards)}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "processing event idempotently: %v", txErr)
 	}
 
-	if _, exists := s.rewardsCacheDev[req.DevKey][t]; exists {
-		// This reward is already granted to the Dev so bail.
+	for _, r := range granted {
+		s.rewardsCacheMu.Lock()
+		s.rewardsCacheOrg[req.OrgKey][r.Type] = struct{}{}
+		s.rewardsCacheDev[req.DevKey][r.Type] = struct{}{}
+		s.rewardsCacheMu.Unlock()
+
+		if err := s.invalidator.Publish(ctx, invalidateMsg(req.OrgKey, req.DevKey, r.Type)); err != nil {
+			log.Errorf("publishing cache invalidation: %v", err)
+		}
+	}
+	if len(granted) == 0 {
 		return &pb.ProcessAnalyticsEventResponse{}, nil
 	}
 
-	r := &reward{
-		OrgKey:            req.OrgKey,
-		DevKey:            req.DevKey,
-		Type:              t,
-		Factor:            rewardTypeMeta[t].factor,
-		BaseAttoFILReward: s.baseAttoFILReward,
-		CreatedAt:         time.Now(),
+	for _, r := range granted {
+		amount := new(big.Int).Mul(big.NewInt(int64(r.Factor)), s.baseAttoFILReward)
+		if err := s.ac.Track(
+			ctx,
+			r.OrgKey,
+			analyticspb.AccountType_ACCOUNT_TYPE_ORG,
+			analyticspb.Event_EVENT_FIL_REWARD,
+			analytics.WithProperties(map[string]interface{}{
+				"type":                 r.Type,
+				"factor":               r.Factor,
+				"base_atto_fil_reward": r.BaseAttoFILReward,
+				"amount":               filunits.FormatAttoFIL(amount),
+				"dev_key":              req.DevKey,
+			}),
+		); err != nil {
+			log.Errorf("calling analytics track: %v", err)
+		}
 	}
 
-	if _, err := s.rewardsCol.InsertOne(ctx, r); err != nil {
-		return nil, status.Errorf(codes.Internal, "inserting reward: %v", err)
+	return &pb.ProcessAnalyticsEventResponse{Rewards: toPbRewards(granted)}, nil
+}
+
+// alreadyGranted reports whether candidate's reward type has already been
+// granted to the key(s) its rule's one_shot_per scopes to.
+func alreadyGranted(cacheOrg, cacheDev map[string]map[pb.RewardType]struct{}, req *pb.ProcessAnalyticsEventRequest, c rules.Candidate) bool {
+	switch c.Rule.Grant.OneShotPer {
+	case rules.OneShotDev:
+		_, exists := cacheDev[req.DevKey][c.RewardType]
+		return exists
+	case rules.OneShotOrg:
+		_, exists := cacheOrg[req.OrgKey][c.RewardType]
+		return exists
+	default:
+		if _, exists := cacheOrg[req.OrgKey][c.RewardType]; exists {
+			return true
+		}
+		_, exists := cacheDev[req.DevKey][c.RewardType]
+		return exists
 	}
+}
 
-	s.rewardsCacheOrg[req.OrgKey][t] = struct{}{}
-	s.rewardsCacheDev[req.DevKey][t] = struct{}{}
+// releaseLock releases lck, logging rather than propagating an error: a
+// stuck distributed lock is recovered by its own TTL, so it isn't worth
+// failing an otherwise-successful RPC over.
+func releaseLock(lck lock.Lock) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := lck.Release(ctx); err != nil {
+		log.Errorf("releasing lock: %v", err)
+	}
+}
 
-	if err := s.ac.Track(
-		ctx,
-		r.OrgKey,
-		analyticspb.AccountType_ACCOUNT_TYPE_ORG,
-		analyticspb.Event_EVENT_FIL_REWARD,
-		analytics.WithProperties(map[string]interface{}{
-			"type":                 r.Type,
-			"factor":               rewardTypeMeta[r.Type].factor,
-			"base_atto_fil_reward": s.baseAttoFILReward,
-			"amount":               rewardTypeMeta[r.Type].factor * s.baseAttoFILReward,
-			"dev_key":              req.DevKey,
-		}),
-	); err != nil {
-		log.Errorf("calling analytics track: %v", err)
+func invalidateMsg(orgKey, devKey string, t pb.RewardType) string {
+	return fmt.Sprintf("%s|%s|%d", orgKey, devKey, t)
+}
+
+func parseInvalidateMsg(msg string) (orgKey, devKey string, t pb.RewardType, ok bool) {
+	parts := strings.SplitN(msg, "|", 3)
+	if len(parts) != 3 {
+		return "", "", 0, false
+	}
+	n, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return "", "", 0, false
+	}
+	return parts[0], parts[1], pb.RewardType(n), true
+}
+
+// watchInvalidations applies peer-published cache invalidations so this
+// replica's in-memory rewardsCache{Org,Dev} stay in sync with grants made
+// elsewhere, without needing to hit mongo on every read.
+func (s *Service) watchInvalidations(ctx context.Context) {
+	msgs, err := s.invalidator.Subscribe(ctx)
+	if err != nil {
+		log.Errorf("subscribing to cache invalidations: %v", err)
+		return
+	}
+	for msg := range msgs {
+		orgKey, devKey, t, ok := parseInvalidateMsg(msg)
+		if !ok {
+			log.Errorf("ignoring malformed cache invalidation message: %q", msg)
+			continue
+		}
+		s.rewardsCacheMu.Lock()
+		ensureKeyRewardCache(s.rewardsCacheOrg, orgKey)
+		ensureKeyRewardCache(s.rewardsCacheDev, devKey)
+		s.rewardsCacheOrg[orgKey][t] = struct{}{}
+		s.rewardsCacheDev[devKey][t] = struct{}{}
+		s.rewardsCacheMu.Unlock()
+	}
+}
+
+// keysetFilter adds a (created_at, _id) compound-cursor constraint to
+// filter: records strictly after startAt when ascending (or before, when
+// descending), with startAfterID breaking ties among records that share
+// startAt so pagination is stable even when many records land in the same
+// instant.
+func keysetFilter(filter bson.M, ascending bool, startAt *timestamppb.Timestamp, startAfterID string) error {
+	if startAt == nil {
+		return nil
+	}
+	cmp := "$lt"
+	if ascending {
+		cmp = "$gt"
+	}
+	t := startAt.AsTime()
+	if startAfterID == "" {
+		filter["created_at"] = bson.M{cmp: t}
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(startAfterID)
+	if err != nil {
+		return fmt.Errorf("parsing start_after_id: %v", err)
+	}
+	filter["$or"] = bson.A{
+		bson.M{"created_at": bson.M{cmp: t}},
+		bson.M{"created_at": t, "_id": bson.M{cmp: id}},
+	}
+	return nil
+}
+
+// dateRangeFilter builds a created_at range filter from an optional
+// [startAt, endAt) window, or nil if neither bound is set.
+func dateRangeFilter(startAt, endAt *timestamppb.Timestamp) bson.M {
+	if startAt == nil && endAt == nil {
+		return nil
+	}
+	f := bson.M{}
+	if startAt != nil {
+		f["$gte"] = startAt.AsTime()
+	}
+	if endAt != nil {
+		f["$lt"] = endAt.AsTime()
 	}
+	return f
+}
 
-	return &pb.ProcessAnalyticsEventResponse{Reward: toPbReward(r)}, nil
+// dateTruncUnit maps a pb.TimeBucket to the unit name $dateTrunc expects.
+func dateTruncUnit(b pb.TimeBucket) (string, error) {
+	switch b {
+	case pb.TimeBucket_TIME_BUCKET_DAY:
+		return "day", nil
+	case pb.TimeBucket_TIME_BUCKET_WEEK:
+		return "week", nil
+	case pb.TimeBucket_TIME_BUCKET_MONTH:
+		return "month", nil
+	default:
+		return "", fmt.Errorf("must provide a time bucket")
+	}
 }
 
-func (s *Service) ListRewards(ctx context.Context, req *pb.ListRewardsRequest) (*pb.ListRewardsResponse, error) {
+func (s *Service) ListRewards(req *pb.ListRewardsRequest, stream pb.FilRewardsService_ListRewardsServer) error {
 	findOpts := options.Find()
 	if req.Limit > 0 {
 		findOpts.Limit = &req.Limit
@@ -276,7 +639,7 @@ func (s *Service) ListRewards(ctx context.Context, req *pb.ListRewardsRequest) (
 	if req.Ascending {
 		sort = 1
 	}
-	findOpts.Sort = bson.D{primitive.E{Key: "created_at", Value: sort}}
+	findOpts.Sort = bson.D{primitive.E{Key: "created_at", Value: sort}, primitive.E{Key: "_id", Value: sort}}
 	filter := bson.M{}
 	if req.OrgKeyFilter != "" {
 		filter["org_key"] = req.OrgKeyFilter
@@ -287,57 +650,127 @@ func (s *Service) ListRewards(ctx context.Context, req *pb.ListRewardsRequest) (
 	if req.RewardTypeFilter != pb.RewardType_REWARD_TYPE_UNSPECIFIED {
 		filter["type"] = req.RewardTypeFilter
 	}
-	comp := "$lt"
-	if req.StartAt != nil {
-		if req.Ascending {
-			comp = "$gt"
-		}
-		t := req.StartAt.AsTime()
-		filter["created_at"] = bson.M{comp: &t}
+	if err := keysetFilter(filter, req.Ascending, req.StartAt, req.StartAfterId); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
 	}
+
+	ctx := stream.Context()
 	cursor, err := s.rewardsCol.Find(ctx, filter, findOpts)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "querying rewards: %v", err)
+		return status.Errorf(codes.Internal, "querying rewards: %v", err)
 	}
 	defer cursor.Close(ctx)
-	var rewards []reward
-	err = cursor.All(ctx, &rewards)
+	for cursor.Next(ctx) {
+		var rec reward
+		if err := cursor.Decode(&rec); err != nil {
+			return status.Errorf(codes.Internal, "decoding reward: %v", err)
+		}
+		if err := stream.Send(toPbReward(&rec)); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return status.Errorf(codes.Internal, "iterating rewards: %v", err)
+	}
+	return nil
+}
+
+func (s *Service) AggregateRewards(ctx context.Context, req *pb.AggregateRewardsRequest) (*pb.AggregateRewardsResponse, error) {
+	unit, err := dateTruncUnit(req.Bucket)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "decoding reward query results: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	match := bson.M{}
+	if req.OrgKeyFilter != "" {
+		match["org_key"] = req.OrgKeyFilter
+	}
+	if req.DevKeyFilter != "" {
+		match["dev_key"] = req.DevKeyFilter
+	}
+	if req.RewardTypeFilter != pb.RewardType_REWARD_TYPE_UNSPECIFIED {
+		match["type"] = req.RewardTypeFilter
+	}
+	if createdAt := dateRangeFilter(req.StartAt, req.EndAt); createdAt != nil {
+		match["created_at"] = createdAt
 	}
 
-	more := false
-	var startAt *time.Time
-	if len(rewards) > 0 {
-		lastCreatedAt := &rewards[len(rewards)-1].CreatedAt
-		filter["created_at"] = bson.M{comp: *lastCreatedAt}
-		res := s.rewardsCol.FindOne(ctx, filter)
-		if res.Err() != nil && res.Err() != mongo.ErrNoDocuments {
-			return nil, status.Errorf(codes.Internal, "checking for more data: %v", err)
-		}
-		if res.Err() != mongo.ErrNoDocuments {
-			more = true
-			startAt = lastCreatedAt
-		}
+	// Grouping sums factor*base_atto_fil_reward as Decimal128 rather than a
+	// native numeric type: attoFIL amounts routinely exceed what a double
+	// can represent exactly, and the aggregation pipeline has no
+	// arbitrary-precision integer type the way math/big does client-side.
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$set", Value: bson.M{
+			"bucket_start": bson.M{"$dateTrunc": bson.M{"date": "$created_at", "unit": unit}},
+			"amount":       bson.M{"$multiply": bson.A{bson.M{"$toDecimal": "$factor"}, bson.M{"$toDecimal": "$base_atto_fil_reward"}}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"org_key":      "$org_key",
+				"dev_key":      "$dev_key",
+				"type":         "$type",
+				"bucket_start": "$bucket_start",
+			},
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": "$amount"},
+		}}},
 	}
-	var pbRewards []*pb.Reward
-	for _, rec := range rewards {
-		pbRewards = append(pbRewards, toPbReward(&rec))
+	cursor, err := s.rewardsCol.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "aggregating rewards: %v", err)
 	}
-	res := &pb.ListRewardsResponse{
-		Rewards: pbRewards,
-		More:    more,
+	defer cursor.Close(ctx)
+
+	var aggregates []*pb.RewardAggregate
+	for cursor.Next(ctx) {
+		var row struct {
+			ID struct {
+				OrgKey      string        `bson:"org_key"`
+				DevKey      string        `bson:"dev_key"`
+				Type        pb.RewardType `bson:"type"`
+				BucketStart time.Time     `bson:"bucket_start"`
+			} `bson:"_id"`
+			Count int64                `bson:"count"`
+			Total primitive.Decimal128 `bson:"total"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, status.Errorf(codes.Internal, "decoding reward aggregate: %v", err)
+		}
+		aggregates = append(aggregates, &pb.RewardAggregate{
+			OrgKey:             row.ID.OrgKey,
+			DevKey:             row.ID.DevKey,
+			Type:               row.ID.Type,
+			BucketStart:        timestamppb.New(row.ID.BucketStart),
+			Count:              row.Count,
+			TotalAttoFilReward: row.Total.String(),
+		})
 	}
-	if startAt != nil {
-		res.MoreStartAt = timestamppb.New(*startAt)
+	if err := cursor.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "iterating reward aggregates: %v", err)
 	}
-	return res, nil
+	return &pb.AggregateRewardsResponse{Aggregates: aggregates}, nil
 }
 
 func (s *Service) Claim(ctx context.Context, req *pb.ClaimRequest) (*pb.ClaimResponse, error) {
-	lck := s.semaphores.Get(orgKeyLock(req.OrgKey))
-	lck.Acquire()
-	defer lck.Release()
+	if req.FilAddress == "" {
+		return nil, status.Error(codes.InvalidArgument, "must provide a destination fil address")
+	}
+	if err := payout.ValidateAddress(req.FilAddress); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid fil address: %v", err)
+	}
+	amount, err := filunits.AttoFIL(req.Amount)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid claim amount: %v", err)
+	}
+	if amount.Sign() <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "claim amount %s must be positive", req.Amount)
+	}
+
+	lck, err := s.locker.Acquire(ctx, orgLockKey(req.OrgKey), s.lockTTL)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "acquiring org lock: %v", err)
+	}
+	defer releaseLock(lck)
 
 	totalRewarded, err := s.totalRewarded(ctx, req.OrgKey)
 	if err != nil {
@@ -348,16 +781,17 @@ func (s *Service) Claim(ctx context.Context, req *pb.ClaimRequest) (*pb.ClaimRes
 		return nil, status.Errorf(codes.Internal, "calculating total claimed: %v", err)
 	}
 
-	available := totalRewarded - totalClaimed
+	available := new(big.Int).Sub(totalRewarded, totalClaimed)
 
-	if req.Amount > available {
-		return nil, status.Errorf(codes.InvalidArgument, "claim amount %d is greater than available reward balance %d", req.Amount, available)
+	if amount.Cmp(available) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "claim amount %s is greater than available reward balance %s", req.Amount, filunits.FormatAttoFIL(available))
 	}
 
 	c := &claim{
+		ID:        primitive.NewObjectID(),
 		OrgKey:    req.OrgKey,
 		ClaimedBy: req.ClaimedBy,
-		Amount:    req.Amount,
+		Amount:    filunits.FormatAttoFIL(amount),
 		CreatedAt: time.Now(),
 	}
 
@@ -365,6 +799,15 @@ func (s *Service) Claim(ctx context.Context, req *pb.ClaimRequest) (*pb.ClaimRes
 		return nil, status.Errorf(codes.Internal, "inserting claim: %v", err)
 	}
 
+	p, err := s.payouts.Create(ctx, c.ID, c.OrgKey, req.FilAddress, c.Amount)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "creating payout: %v", err)
+	}
+	// Submitting is a blocking network call to the configured Payer (e.g. a
+	// Lotus node), so it's left pending here rather than driven inline: the
+	// Reconciler's background sweep picks it up on its next tick, off the
+	// request path and without holding the org lock this call still holds.
+
 	if err := s.ac.Track(
 		ctx,
 		c.OrgKey,
@@ -378,10 +821,162 @@ func (s *Service) Claim(ctx context.Context, req *pb.ClaimRequest) (*pb.ClaimRes
 		log.Errorf("calling analytics track: %v", err)
 	}
 
-	return &pb.ClaimResponse{}, nil
+	return &pb.ClaimResponse{Payout: toPbPayout(p)}, nil
+}
+
+func (s *Service) GetPayout(ctx context.Context, req *pb.GetPayoutRequest) (*pb.GetPayoutResponse, error) {
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "parsing payout id: %v", err)
+	}
+	p, err := s.payouts.Get(ctx, id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, status.Error(codes.NotFound, "payout not found")
+		}
+		return nil, status.Errorf(codes.Internal, "getting payout: %v", err)
+	}
+	return &pb.GetPayoutResponse{Payout: toPbPayout(p)}, nil
+}
+
+func (s *Service) ListPayouts(ctx context.Context, req *pb.ListPayoutsRequest) (*pb.ListPayoutsResponse, error) {
+	filter := bson.M{}
+	if req.OrgKeyFilter != "" {
+		filter["org_key"] = req.OrgKeyFilter
+	}
+	if req.StateFilter != pb.PayoutState_PAYOUT_STATE_UNSPECIFIED {
+		filter["state"] = fromPbPayoutState(req.StateFilter)
+	}
+	var startAt *time.Time
+	if req.StartAt != nil {
+		t := req.StartAt.AsTime()
+		startAt = &t
+	}
+	var startAfterID *primitive.ObjectID
+	if req.StartAfterId != "" {
+		id, err := primitive.ObjectIDFromHex(req.StartAfterId)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "parsing start_after_id: %v", err)
+		}
+		startAfterID = &id
+	}
+	payouts, more, moreStartAt, moreStartAfterID, err := s.payouts.List(ctx, filter, req.Limit, req.Ascending, startAt, startAfterID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "listing payouts: %v", err)
+	}
+	pbPayouts := make([]*pb.Payout, len(payouts))
+	for i := range payouts {
+		pbPayouts[i] = toPbPayout(&payouts[i])
+	}
+	res := &pb.ListPayoutsResponse{Payouts: pbPayouts, More: more}
+	if moreStartAt != nil {
+		res.MoreStartAt = timestamppb.New(*moreStartAt)
+	}
+	if moreStartAfterID != nil {
+		res.MoreStartAfterId = moreStartAfterID.Hex()
+	}
+	return res, nil
+}
+
+func (s *Service) RetryPayout(ctx context.Context, req *pb.RetryPayoutRequest) (*pb.RetryPayoutResponse, error) {
+	id, err := primitive.ObjectIDFromHex(req.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "parsing payout id: %v", err)
+	}
+	p, err := s.payouts.Get(ctx, id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, status.Error(codes.NotFound, "payout not found")
+		}
+		return nil, status.Errorf(codes.Internal, "getting payout: %v", err)
+	}
+	if p.State != payout.StateFailed && p.State != payout.StatePending {
+		return nil, status.Errorf(codes.FailedPrecondition, "payout is in state %s and cannot be retried", p.State)
+	}
+	amount, err := filunits.AttoFIL(p.AmountAttoFIL)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "parsing payout amount: %v", err)
+	}
+	claimed, err := s.reconciler.Submit(ctx, p, amount)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "retrying payout: %v", err)
+	}
+	if !claimed {
+		return nil, status.Error(codes.Aborted, "payout is already being submitted")
+	}
+	p, err = s.payouts.Get(ctx, id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "reloading payout: %v", err)
+	}
+	return &pb.RetryPayoutResponse{Payout: toPbPayout(p)}, nil
+}
+
+func (s *Service) ReloadRules(ctx context.Context, req *pb.ReloadRulesRequest) (*pb.ReloadRulesResponse, error) {
+	if err := s.rules.Reload(); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "reloading rules: %v", err)
+	}
+	return &pb.ReloadRulesResponse{RuleCount: int32(len(s.rules.Rules()))}, nil
+}
+
+func (s *Service) ListRules(ctx context.Context, req *pb.ListRulesRequest) (*pb.ListRulesResponse, error) {
+	loaded := s.rules.Rules()
+	pbRules := make([]*pb.Rule, len(loaded))
+	for i, r := range loaded {
+		pbRules[i] = toPbRule(r)
+	}
+	return &pb.ListRulesResponse{Rules: pbRules}, nil
+}
+
+// ReplayEvents re-evaluates reward rules for analytics events recorded in
+// [req.From, req.To]. Each replayed event is assigned a deterministic
+// idempotency key derived from the event's own id, so events that already
+// granted a reward the first time through are skipped rather than
+// double-granted.
+func (s *Service) ReplayEvents(ctx context.Context, req *pb.ReplayEventsRequest) (*pb.ReplayEventsResponse, error) {
+	if s.ac == nil {
+		return nil, status.Error(codes.FailedPrecondition, "no analytics client configured")
+	}
+	events, err := s.ac.ListEvents(ctx, req.From.AsTime(), req.To.AsTime())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "listing analytics events to replay: %v", err)
+	}
+
+	res := &pb.ReplayEventsResponse{}
+	for _, ev := range events {
+		out, err := s.ProcessAnalyticsEvent(ctx, &pb.ProcessAnalyticsEventRequest{
+			OrgKey:         ev.OrgKey,
+			DevKey:         ev.DevKey,
+			AnalyticsEvent: ev.Event,
+			OrgTier:        ev.OrgTier,
+			DevPlan:        ev.DevPlan,
+			IdempotencyKey: "replay:" + ev.Id,
+		})
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "replaying event %s: %v", ev.Id, err)
+		}
+		res.EventsReplayed++
+		res.RewardsGranted += int32(len(out.Rewards))
+	}
+	return res, nil
+}
+
+func toPbRule(r rules.Rule) *pb.Rule {
+	return &pb.Rule{
+		Match: &pb.RuleMatch{
+			Event:    r.Match.Event,
+			OrgTier:  r.Match.OrgTier,
+			DevPlan:  r.Match.DevPlan,
+			MinCount: r.Match.MinCount,
+		},
+		Grant: &pb.RuleGrant{
+			Type:       r.Grant.Type,
+			Factor:     r.Grant.Factor,
+			OneShotPer: string(r.Grant.OneShotPer),
+		},
+	}
 }
 
-func (s *Service) ListClaims(ctx context.Context, req *pb.ListClaimsRequest) (*pb.ListClaimsResponse, error) {
+func (s *Service) ListClaims(req *pb.ListClaimsRequest, stream pb.FilRewardsService_ListClaimsServer) error {
 	findOpts := options.Find()
 	if req.Limit > 0 {
 		findOpts.Limit = &req.Limit
@@ -390,7 +985,7 @@ func (s *Service) ListClaims(ctx context.Context, req *pb.ListClaimsRequest) (*p
 	if req.Ascending {
 		sort = 1
 	}
-	findOpts.Sort = bson.D{primitive.E{Key: "created_at", Value: sort}}
+	findOpts.Sort = bson.D{primitive.E{Key: "created_at", Value: sort}, primitive.E{Key: "_id", Value: sort}}
 	filter := bson.M{}
 	if req.OrgKeyFilter != "" {
 		filter["org_key"] = req.OrgKeyFilter
@@ -398,57 +993,103 @@ func (s *Service) ListClaims(ctx context.Context, req *pb.ListClaimsRequest) (*p
 	if req.ClaimedByFilter != "" {
 		filter["claimed_by"] = req.ClaimedByFilter
 	}
-	comp := "$lt"
-	if req.StartAt != nil {
-		if req.Ascending {
-			comp = "$gt"
-		}
-		t := req.StartAt.AsTime()
-		filter["created_at"] = bson.M{comp: &t}
+	if err := keysetFilter(filter, req.Ascending, req.StartAt, req.StartAfterId); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
 	}
+
+	ctx := stream.Context()
 	cursor, err := s.claimsCol.Find(ctx, filter, findOpts)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "querying claims: %v", err)
+		return status.Errorf(codes.Internal, "querying claims: %v", err)
 	}
 	defer cursor.Close(ctx)
-	var claims []claim
-	err = cursor.All(ctx, &claims)
+	for cursor.Next(ctx) {
+		var rec claim
+		if err := cursor.Decode(&rec); err != nil {
+			return status.Errorf(codes.Internal, "decoding claim: %v", err)
+		}
+		if err := stream.Send(toPbClaim(&rec)); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return status.Errorf(codes.Internal, "iterating claims: %v", err)
+	}
+	return nil
+}
+
+func (s *Service) AggregateClaims(ctx context.Context, req *pb.AggregateClaimsRequest) (*pb.AggregateClaimsResponse, error) {
+	unit, err := dateTruncUnit(req.Bucket)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "decoding claim query results: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	match := bson.M{}
+	if req.OrgKeyFilter != "" {
+		match["org_key"] = req.OrgKeyFilter
+	}
+	if req.ClaimedByFilter != "" {
+		match["claimed_by"] = req.ClaimedByFilter
+	}
+	if createdAt := dateRangeFilter(req.StartAt, req.EndAt); createdAt != nil {
+		match["created_at"] = createdAt
 	}
 
-	more := false
-	var startAt *time.Time
-	if len(claims) > 0 {
-		lastCreatedAt := &claims[len(claims)-1].CreatedAt
-		filter["created_at"] = bson.M{comp: *lastCreatedAt}
-		res := s.claimsCol.FindOne(ctx, filter)
-		if res.Err() != nil && res.Err() != mongo.ErrNoDocuments {
-			return nil, status.Errorf(codes.Internal, "checking for more data: %v", err)
-		}
-		if res.Err() != mongo.ErrNoDocuments {
-			more = true
-			startAt = lastCreatedAt
-		}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$set", Value: bson.M{
+			"bucket_start": bson.M{"$dateTrunc": bson.M{"date": "$created_at", "unit": unit}},
+			"amount":       bson.M{"$toDecimal": "$amount"},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"org_key":      "$org_key",
+				"claimed_by":   "$claimed_by",
+				"bucket_start": "$bucket_start",
+			},
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": "$amount"},
+		}}},
 	}
-	var pbClaims []*pb.Claim
-	for _, rec := range claims {
-		pbClaims = append(pbClaims, toPbClaim(&rec))
+	cursor, err := s.claimsCol.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "aggregating claims: %v", err)
 	}
-	res := &pb.ListClaimsResponse{
-		Claims: pbClaims,
-		More:   more,
+	defer cursor.Close(ctx)
+
+	var aggregates []*pb.ClaimAggregate
+	for cursor.Next(ctx) {
+		var row struct {
+			ID struct {
+				OrgKey      string    `bson:"org_key"`
+				ClaimedBy   string    `bson:"claimed_by"`
+				BucketStart time.Time `bson:"bucket_start"`
+			} `bson:"_id"`
+			Count int64                `bson:"count"`
+			Total primitive.Decimal128 `bson:"total"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, status.Errorf(codes.Internal, "decoding claim aggregate: %v", err)
+		}
+		aggregates = append(aggregates, &pb.ClaimAggregate{
+			OrgKey:      row.ID.OrgKey,
+			ClaimedBy:   row.ID.ClaimedBy,
+			BucketStart: timestamppb.New(row.ID.BucketStart),
+			Count:       row.Count,
+			TotalAmount: row.Total.String(),
+		})
 	}
-	if startAt != nil {
-		res.MoreStartAt = timestamppb.New(*startAt)
+	if err := cursor.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "iterating claim aggregates: %v", err)
 	}
-	return res, nil
+	return &pb.AggregateClaimsResponse{Aggregates: aggregates}, nil
 }
 
 func (s *Service) Balance(ctx context.Context, req *pb.BalanceRequest) (*pb.BalanceResponse, error) {
-	lck := s.semaphores.Get(orgKeyLock(req.OrgKey))
-	lck.Acquire()
-	defer lck.Release()
+	lck, err := s.locker.Acquire(ctx, orgLockKey(req.OrgKey), s.lockTTL)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "acquiring org lock: %v", err)
+	}
+	defer releaseLock(lck)
 
 	totalRewarded, err := s.totalRewarded(ctx, req.OrgKey)
 	if err != nil {
@@ -458,10 +1099,19 @@ func (s *Service) Balance(ctx context.Context, req *pb.BalanceRequest) (*pb.Bala
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "calculating total claimed: %v", err)
 	}
-	return &pb.BalanceResponse{Rewarded: totalRewarded, Claimed: totalClaimed, Available: totalRewarded - totalClaimed}, nil
+	available := new(big.Int).Sub(totalRewarded, totalClaimed)
+	return &pb.BalanceResponse{
+		Rewarded:  filunits.FormatAttoFIL(totalRewarded),
+		Claimed:   filunits.FormatAttoFIL(totalClaimed),
+		Available: filunits.FormatAttoFIL(available),
+	}, nil
 }
 
 func (s *Service) Close() {
+	signal.Stop(s.sighup)
+	close(s.sighup)
+	s.reconciler.Stop()
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 	if err := s.rewardsCol.Database().Client().Disconnect(ctx); err != nil {
@@ -484,49 +1134,62 @@ func (s *Service) Close() {
 	log.Info("gRPC server stopped")
 }
 
-func (s *Service) totalRewarded(ctx context.Context, orgKey string) (int32, error) {
-	cursor, err := s.rewardsCol.Aggregate(ctx, bson.A{
-		bson.M{"$match": bson.M{"org_key": orgKey}},
-		bson.M{"$project": bson.M{"amt": bson.M{"$multiply": bson.A{"$factor", "$base_atto_fil_reward"}}}},
-		bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$amt"}}},
-	})
+// totalRewarded sums factor*base_atto_fil_reward across orgKey's rewards.
+// This is done client-side, rather than via a $multiply/$sum pipeline,
+// because attoFIL amounts routinely exceed the range mongo's aggregation
+// arithmetic operates in.
+func (s *Service) totalRewarded(ctx context.Context, orgKey string) (*big.Int, error) {
+	cursor, err := s.rewardsCol.Find(ctx, bson.M{"org_key": orgKey})
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
+	defer cursor.Close(ctx)
+	total := new(big.Int)
 	for cursor.Next(ctx) {
-		elements, err := cursor.Current.Elements()
-		if err != nil {
-			return -1, err
+		var rec reward
+		if err := cursor.Decode(&rec); err != nil {
+			return nil, err
 		}
-		for _, e := range elements {
-			if e.Key() == "total" {
-				return e.Value().Int32(), nil
-			}
+		base, err := filunits.AttoFIL(rec.BaseAttoFILReward)
+		if err != nil {
+			return nil, fmt.Errorf("parsing base_atto_fil_reward %q: %v", rec.BaseAttoFILReward, err)
 		}
+		amt := new(big.Int).Mul(big.NewInt(int64(rec.Factor)), base)
+		total.Add(total, amt)
 	}
-	return -1, fmt.Errorf("no total rewarded calculation found")
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return total, nil
 }
 
-func (s *Service) totalClaimed(ctx context.Context, orgKey string) (int32, error) {
-	cursor, err := s.rewardsCol.Aggregate(ctx, bson.A{
-		bson.M{"$match": bson.M{"org_key": orgKey}},
-		bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}},
-	})
+// totalClaimed sums the amount field across orgKey's claims, client-side for
+// the same overflow-avoidance reason as totalRewarded.
+func (s *Service) totalClaimed(ctx context.Context, orgKey string) (*big.Int, error) {
+	cursor, err := s.claimsCol.Find(ctx, bson.M{"org_key": orgKey})
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
+	defer cursor.Close(ctx)
+	total := new(big.Int)
 	for cursor.Next(ctx) {
-		elements, err := cursor.Current.Elements()
-		if err != nil {
-			return -1, err
+		var rec claim
+		if err := cursor.Decode(&rec); err != nil {
+			return nil, err
 		}
-		for _, e := range elements {
-			if e.Key() == "total" {
-				return e.Value().Int32(), nil
-			}
+		if rec.Amount == "" {
+			continue
+		}
+		amt, err := filunits.AttoFIL(rec.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("parsing claim amount %q: %v", rec.Amount, err)
 		}
+		total.Add(total, amt)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
 	}
-	return -1, fmt.Errorf("no total claimed calculation found")
+	return total, nil
 }
 
 func (s *Service) get(ctx context.Context, orgKey string, t pb.RewardType) (*reward, error) {
@@ -542,8 +1205,30 @@ func (s *Service) get(ctx context.Context, orgKey string, t pb.RewardType) (*rew
 	return &r, nil
 }
 
+// toPbRewards converts rewards to their pb representation, in order.
+func toPbRewards(rewards []*reward) []*pb.Reward {
+	out := make([]*pb.Reward, len(rewards))
+	for i, r := range rewards {
+		out[i] = toPbReward(r)
+	}
+	return out
+}
+
+func (s *Service) getProcessedEvent(ctx context.Context, idempotencyKey string) (*processedEvent, error) {
+	res := s.processedEventsCol.FindOne(ctx, bson.M{"idempotency_key": idempotencyKey})
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+	var pe processedEvent
+	if err := res.Decode(&pe); err != nil {
+		return nil, err
+	}
+	return &pe, nil
+}
+
 func toPbReward(rec *reward) *pb.Reward {
 	res := &pb.Reward{
+		Id:                rec.ID.Hex(),
 		OrgKey:            rec.OrgKey,
 		DevKey:            rec.DevKey,
 		Type:              rec.Type,
@@ -554,8 +1239,60 @@ func toPbReward(rec *reward) *pb.Reward {
 	return res
 }
 
+func toPbPayout(p *payout.Payout) *pb.Payout {
+	res := &pb.Payout{
+		Id:        p.ID.Hex(),
+		ClaimId:   p.ClaimID.Hex(),
+		OrgKey:    p.OrgKey,
+		ToAddr:    p.ToAddr,
+		Cid:       p.Cid,
+		State:     toPbPayoutState(p.State),
+		Attempts:  p.Attempts,
+		LastError: p.LastError,
+		CreatedAt: timestamppb.New(p.CreatedAt),
+	}
+	if p.ConfirmedAt != nil {
+		res.ConfirmedAt = timestamppb.New(*p.ConfirmedAt)
+	}
+	return res
+}
+
+func toPbPayoutState(s payout.State) pb.PayoutState {
+	switch s {
+	case payout.StatePending, payout.StateSubmitting:
+		// StateSubmitting is an internal-only detail of how the Reconciler
+		// claims a payout before submitting it; callers just see "pending"
+		// until it's actually been broadcast.
+		return pb.PayoutState_PAYOUT_STATE_PENDING
+	case payout.StateSubmitted:
+		return pb.PayoutState_PAYOUT_STATE_SUBMITTED
+	case payout.StateConfirmed:
+		return pb.PayoutState_PAYOUT_STATE_CONFIRMED
+	case payout.StateFailed:
+		return pb.PayoutState_PAYOUT_STATE_FAILED
+	default:
+		return pb.PayoutState_PAYOUT_STATE_UNSPECIFIED
+	}
+}
+
+func fromPbPayoutState(s pb.PayoutState) payout.State {
+	switch s {
+	case pb.PayoutState_PAYOUT_STATE_PENDING:
+		return payout.StatePending
+	case pb.PayoutState_PAYOUT_STATE_SUBMITTED:
+		return payout.StateSubmitted
+	case pb.PayoutState_PAYOUT_STATE_CONFIRMED:
+		return payout.StateConfirmed
+	case pb.PayoutState_PAYOUT_STATE_FAILED:
+		return payout.StateFailed
+	default:
+		return ""
+	}
+}
+
 func toPbClaim(rec *claim) *pb.Claim {
 	res := &pb.Claim{
+		Id:        rec.ID.Hex(),
 		OrgKey:    rec.OrgKey,
 		ClaimedBy: rec.ClaimedBy,
 		Amount:    rec.Amount,
@@ -564,8 +1301,27 @@ func toPbClaim(rec *claim) *pb.Claim {
 	return res
 }
 
+// migrateMonetaryFields rewrites any reward/claim documents left over from
+// before monetary values moved from int32 to string-encoded attoFIL, so that
+// decoding them into the current reward/claim structs always sees a string.
+func migrateMonetaryFields(ctx context.Context, rewardsCol, claimsCol *mongo.Collection) error {
+	if _, err := rewardsCol.UpdateMany(ctx,
+		bson.M{"base_atto_fil_reward": bson.M{"$type": "int"}},
+		bson.A{bson.M{"$set": bson.M{"base_atto_fil_reward": bson.M{"$toString": "$base_atto_fil_reward"}}}},
+	); err != nil {
+		return fmt.Errorf("migrating reward base_atto_fil_reward: %v", err)
+	}
+	if _, err := claimsCol.UpdateMany(ctx,
+		bson.M{"amount": bson.M{"$type": "int"}},
+		bson.A{bson.M{"$set": bson.M{"amount": bson.M{"$toString": "$amount"}}}},
+	); err != nil {
+		return fmt.Errorf("migrating claim amount: %v", err)
+	}
+	return nil
+}
+
 func ensureKeyRewardCache(keyCache map[string]map[pb.RewardType]struct{}, key string) {
 	if _, exists := keyCache[key]; !exists {
 		keyCache[key] = map[pb.RewardType]struct{}{}
 	}
-}
\ No newline at end of file
+}